@@ -0,0 +1,107 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/polynetwork/fabric-relayer/config"
+	"github.com/polynetwork/fabric-relayer/db"
+	"github.com/polynetwork/fabric-relayer/log"
+	"github.com/polynetwork/fabric-relayer/manager"
+	"github.com/polynetwork/fabric-relayer/tools"
+	sdk "github.com/polynetwork/poly-go-sdk"
+)
+
+// shutdownTimeout bounds how long main waits for in-flight ImportOuterTransfer
+// calls to finish once a shutdown signal is received.
+const shutdownTimeout = 30 * time.Second
+
+func main() {
+	servconfig := config.DefConfig
+	log.InitLog(int(servconfig.LogLevel), "./Log/", log.Stdout)
+
+	polySdk := sdk.NewPolySdk()
+	if err := setUpPoly(polySdk, servconfig.PolyConfig.RestURL); err != nil {
+		log.Errorf("main - failed to set up poly sdk: %v", err)
+		return
+	}
+
+	client, err := tools.NewFabricSdk(servconfig.FabricConfig)
+	if err != nil {
+		log.Errorf("main - failed to set up fabric sdk: %v", err)
+		return
+	}
+
+	boltDB, err := db.NewBoltDB(servconfig.BoltDbPath)
+	if err != nil {
+		log.Errorf("main - failed to open boltdb: %v", err)
+		return
+	}
+
+	mgr, err := manager.NewFabricManager(servconfig, polySdk, client, boltDB)
+	if err != nil {
+		log.Errorf("main - failed to create fabric manager: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go mgr.MonitorChain(ctx)
+	go mgr.HeaderSyncer().MonitorHeaderSync(ctx)
+
+	if err := mgr.SignerSet().Watch(ctx, servconfig.ConfigFile, config.ReloadFabricConfig); err != nil {
+		log.Errorf("main - failed to watch config for signer hot reload: %v", err)
+	}
+
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/rewind", mgr.RewindHandler)
+	adminMux.Handle("/metrics", manager.MetricsHandler())
+	adminServer := &http.Server{Addr: servconfig.AdminListenAddr, Handler: adminMux}
+	go func() {
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("main - admin server error: %v", err)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	log.Infof("main - shutdown signal received, draining")
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	adminServer.Shutdown(shutdownCtx)
+	if err := mgr.Shutdown(shutdownTimeout); err != nil {
+		log.Errorf("main - shutdown error: %v", err)
+	}
+}
+
+func setUpPoly(polySdk *sdk.PolySdk, rpcAddr string) error {
+	polySdk.NewRpcClient().SetAddress(rpcAddr)
+	hdr, err := polySdk.GetHeaderByHeight(0)
+	if err != nil {
+		return err
+	}
+	polySdk.SetChainId(hdr.ChainID)
+	return nil
+}
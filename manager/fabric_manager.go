@@ -17,12 +17,10 @@
 package manager
 
 import (
-	"crypto"
-	"crypto/ecdsa"
-	"crypto/rand"
+	"context"
 	"encoding/binary"
-	"encoding/hex"
 	"encoding/pem"
+	"fmt"
 	"github.com/polynetwork/fabric-relayer/config"
 	"github.com/polynetwork/fabric-relayer/db"
 	"github.com/polynetwork/fabric-relayer/log"
@@ -32,9 +30,9 @@ import (
 	"github.com/polynetwork/poly/native/service/cross_chain_manager/fabric"
 	scom "github.com/polynetwork/poly/native/service/header_sync/common"
 	autils "github.com/polynetwork/poly/native/service/utils"
-	"github.com/tjfoc/gmsm/pkcs12"
 	"github.com/tjfoc/gmsm/sm2"
 	"io/ioutil"
+	"sync"
 	"time"
 )
 
@@ -43,11 +41,13 @@ type FabricManager struct {
 	client        *tools.FabricSdk
 	polySdk       *sdk.PolySdk
 	polySigner    *sdk.Account
-	exitChan      chan int
 	db            *db.BoltDB
+	heightMu      sync.RWMutex
 	currentHeight uint64
-	fabPrivks []*ecdsa.PrivateKey
-	multiTrustChain scom.MultiCertTrustChain
+	signerSet    *SignerSet
+	headerSyncer *HeaderSyncer
+
+	inflight sync.WaitGroup
 }
 
 func NewFabricManager(
@@ -90,67 +90,87 @@ func NewFabricManager(
 		return
 	}
 
-	mtc := scom.MultiCertTrustChain(make([]*scom.CertTrustChain, len(servconfig.FabricConfig.TrustChainFiles)))
-	for i, files := range servconfig.FabricConfig.TrustChainFiles {
+	signerSet, err := NewSignerSet(&servconfig.FabricConfig)
+	if err != nil {
+		log.Errorf("NewFabricManager - failed to build signer set: %v", err)
+		return nil, err
+	}
+
+	log.Infof("NewFabricManager - poly user address: %s", signer.Address.ToBase58())
+
+	mgr = &FabricManager{
+		config:       servconfig,
+		client:       client,
+		polySdk:      ontsdk,
+		polySigner:   signer,
+		db:           boltDB,
+		signerSet:    signerSet,
+		headerSyncer: NewHeaderSyncer(servconfig, ontsdk, signer, client, boltDB, signerSet),
+	}
+	return mgr, nil
+}
+
+// loadTrustChain parses the configured certificate chain files into a
+// MultiCertTrustChain. It backs SignerSet.reload, which is also what
+// NewFabricManager uses for the initial load.
+func loadTrustChain(files [][]string) (scom.MultiCertTrustChain, error) {
+	mtc := scom.MultiCertTrustChain(make([]*scom.CertTrustChain, len(files)))
+	for i, group := range files {
 		tc := &scom.CertTrustChain{
-			Certs: make([]*sm2.Certificate, len(files)),
+			Certs: make([]*sm2.Certificate, len(group)),
 		}
-		for j, tcFile := range files {
+		for j, tcFile := range group {
 			raw, err := ioutil.ReadFile(tcFile)
 			if err != nil {
-				log.Errorf("NewFabricManager - failed to read %s: %v", tcFile, err)
-				return nil, err
+				return nil, fmt.Errorf("loadTrustChain - failed to read %s: %v", tcFile, err)
 			}
 
 			blk, _ := pem.Decode(raw)
 			tc.Certs[j], err = sm2.ParseCertificate(blk.Bytes)
 			if err != nil {
-				log.Errorf("NewFabricManager - failed to parse %s to cert: %v", tcFile, err)
-				return nil, err
+				return nil, fmt.Errorf("loadTrustChain - failed to parse %s to cert: %v", tcFile, err)
 			}
 		}
 		mtc[i] = tc
 	}
+	return mtc, nil
+}
 
-	privks := make([]*ecdsa.PrivateKey, len(servconfig.FabricConfig.PrivateKeyFiles))
-	for i, file := range servconfig.FabricConfig.PrivateKeyFiles {
-		raw, err := ioutil.ReadFile(file)
-		if err != nil {
-			log.Errorf("NewFabricManager - failed to read %s: %v", file, err)
-			return nil, err
-		}
-		blk, _ := pem.Decode(raw)
-		key, err := pkcs12.ParsePKCS8PrivateKey(blk.Bytes)
-		if err != nil {
-			log.Errorf("NewFabricManager - failed to parse %s to private key: %v", file, err)
-			return nil, err
-		}
-		privks[i] = key.(*ecdsa.PrivateKey)
-	}
-
-	log.Infof("NewFabricManager - poly user address: %s", signer.Address.ToBase58())
+// HeaderSyncer returns the subsystem responsible for keeping Poly's view of
+// the Fabric committee up to date. Callers should run its MonitorHeaderSync
+// loop alongside MonitorChain.
+func (e *FabricManager) HeaderSyncer() *HeaderSyncer {
+	return e.headerSyncer
+}
 
-	mgr = &FabricManager{
-		config:     servconfig,
-		exitChan:   make(chan int),
-		client:     client,
-		polySdk:    ontsdk,
-		polySigner: signer,
-		db:         boltDB,
-		multiTrustChain: mtc,
-		fabPrivks: privks,
-	}
-	return mgr, nil
+// SignerSet returns the currently configured event-proof signers. Callers
+// that want hot reload on config changes should call SignerSet().Watch.
+func (e *FabricManager) SignerSet() *SignerSet {
+	return e.signerSet
 }
 
 func (this *FabricManager) init() error {
 	// get latest height
 	latestHeight := this.findLastestHeight()
 	log.Infof("init - latest synced height: %d", latestHeight)
-	this.currentHeight = latestHeight
+	this.setCurrentHeight(latestHeight)
 	return nil
 }
 
+// CurrentHeight returns the last height MonitorChain has fully committed.
+// It is safe to call concurrently with MonitorChain and Rewind.
+func (e *FabricManager) CurrentHeight() uint64 {
+	e.heightMu.RLock()
+	defer e.heightMu.RUnlock()
+	return e.currentHeight
+}
+
+func (e *FabricManager) setCurrentHeight(height uint64) {
+	e.heightMu.Lock()
+	e.currentHeight = height
+	e.heightMu.Unlock()
+}
+
 func (this *FabricManager) findLastestHeight() uint64 {
 	// try to get key
 	var sideChainId uint64 = this.config.FabricConfig.SideChainId
@@ -171,67 +191,110 @@ func (this *FabricManager) findLastestHeight() uint64 {
 	}
 }
 
-func (e *FabricManager) MonitorChain() {
+// MonitorChain runs the main block-processing loop until ctx is cancelled.
+// Callers should cancel ctx and then call Shutdown to drain in-flight work.
+func (e *FabricManager) MonitorChain(ctx context.Context) {
 	err := e.init()
 	if err != nil {
 		log.Errorf("init failed! err: %v", err)
 		return
 	}
+	pl := newPipeline(e, e.config.FabricConfig.Workers)
 	monitorTicker := time.NewTicker(config.FABRIC_MONITOR_INTERVAL)
+	defer monitorTicker.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			log.Infof("MonitorChain - context cancelled, stopping")
+			return
 		case <-monitorTicker.C:
+			if err := e.verifyRecentHeights(reorgCheckWindow); err != nil {
+				log.Errorf("MonitorChain - reorg check failed: %v", err)
+				continue
+			}
+
 			height, err := e.client.GetLatestHeight()
 			if err != nil {
 				log.Errorf("MonitorChain - cannot get node height, err: %s", err)
 				continue
 			}
-			if height - e.currentHeight <= e.config.FabricConfig.BlockConfig {
+			current := e.CurrentHeight()
+			if height-current <= e.config.FabricConfig.BlockConfig {
 				continue
 			}
 			log.Infof("MonitorChain - fabric height is %d", height)
-			for e.currentHeight < height - e.config.FabricConfig.BlockConfig {
-				blockHandleResult := e.HandleNewBlock(e.currentHeight + 1)
-				if blockHandleResult == false {
-					break
-				}
-				e.currentHeight++
-			}
+			target := height - e.config.FabricConfig.BlockConfig
+			committed := pl.run(ctx, current, target)
+			e.setCurrentHeight(committed)
 		}
 	}
 }
 
-func (e *FabricManager) HandleNewBlock(height uint64) bool {
-	events, err := e.client.GetCrossChainEvent(height)
-	if err != nil {
-		log.Errorf("get cross chain event err: %v", err)
-		return false
+// Shutdown waits, up to deadline, for in-flight ImportOuterTransfer calls
+// and any in-flight HeaderSyncer sync to finish, flushes the BoltDB state,
+// and closes the Poly SDK connection. It should be called after the
+// contexts passed to MonitorChain and HeaderSyncer.MonitorHeaderSync are
+// both cancelled - the two goroutines share this db handle, and closing it
+// out from under a still-running syncOnce would race.
+func (e *FabricManager) Shutdown(deadline time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		e.headerSyncer.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(deadline):
+		log.Errorf("Shutdown - timed out waiting for in-flight commits")
 	}
-	for _, event := range events {
-		e.commitCrossChainEvent(uint32(height), event.Data, event.TxHash)
+
+	if err := e.db.Close(); err != nil {
+		log.Errorf("Shutdown - failed to flush boltdb: %v", err)
+		return err
 	}
-	return true
+	e.polySdk.ClearRpcClient()
+	return nil
 }
 
-func (e *FabricManager) commitCrossChainEvent(height uint32, value []byte, txhash []byte) (string, error) {
-	log.Debugf("commit proof, height: %d, value: %s, txhash: %s", height, hex.EncodeToString(value), hex.EncodeToString(txhash))
-
-	hash := crypto.SHA256.New()
-	hash.Write(value)
-	digest := hash.Sum(nil)
+// HealthCheck reports whether the manager can still reach both chains it
+// bridges. It replaces the old stray Test() method, which looped forever
+// polling client.Lock() and was never wired into anything.
+func (e *FabricManager) HealthCheck() error {
+	if _, err := e.client.GetLatestHeight(); err != nil {
+		return fmt.Errorf("fabric node unreachable: %v", err)
+	}
+	if _, err := e.polySdk.GetBlockCount(); err != nil {
+		return fmt.Errorf("poly node unreachable: %v", err)
+	}
+	return nil
+}
 
-	sigs := make([][]byte, len(e.fabPrivks))
-	for i, k := range e.fabPrivks {
-		sig, err := k.Sign(rand.Reader, digest, nil)
-		if err != nil {
-			log.Errorf("No.%d failed to sign: %v", i, err)
-			return "", err
-		}
-		sigs[i] = sig
+// commitSignedEvent submits an already-digested-and-signed event to Poly.
+// The pipeline's signing workers compute sigs in parallel and only
+// serialize on this step. ctx is checked before submitting so a cancelled
+// shutdown doesn't start new on-chain work; inflight tracks the call for
+// Shutdown's drain deadline.
+func (e *FabricManager) commitSignedEvent(ctx context.Context, height uint32, value []byte, sigs [][]byte) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
 	}
 
+	e.inflight.Add(1)
+	defer e.inflight.Done()
+
+	// The event proof only needs to reference the committee epoch that
+	// HeaderSyncer last pushed to Poly's header_sync contract; the full
+	// MultiCertTrustChain no longer needs to ride along on every commit.
+	epoch := e.headerSyncer.CurrentEpoch()
+	if epoch == nil {
+		log.Errorf("commitSignedEvent - no committee epoch synced yet")
+		return "", fmt.Errorf("no committee epoch synced yet")
+	}
 	sink := common.NewZeroCopySink(nil)
-	e.multiTrustChain.Serialization(sink)
+	sink.WriteUint64(epoch.Number)
 
 	tx, err := e.polySdk.Native.Ccm.ImportOuterTransfer(
 		e.config.FabricConfig.SideChainId,
@@ -244,16 +307,8 @@ func (e *FabricManager) commitCrossChainEvent(height uint32, value []byte, txhas
 	if err != nil {
 		log.Errorf("commitProof err: %v", err)
 		return "", err
-	} else {
-		log.Infof("commitProof - send transaction to poly chain: ( poly_txhash: %s, fabric_txhash: %s, height: %d )",
-			tx.ToHexString(), common.ToHexString(txhash), height)
-		return tx.ToHexString(), nil
-	}
-}
-
-func (e *FabricManager) Test() {
-	for true {
-		time.Sleep(time.Second * 30)
-		e.client.Lock()
 	}
+	log.Infof("commitProof - send transaction to poly chain: ( poly_txhash: %s, height: %d )",
+		tx.ToHexString(), height)
+	return tx.ToHexString(), nil
 }
@@ -0,0 +1,219 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/polynetwork/fabric-relayer/config"
+	"github.com/polynetwork/fabric-relayer/db"
+	"github.com/polynetwork/fabric-relayer/log"
+	"github.com/polynetwork/fabric-relayer/tools"
+	sdk "github.com/polynetwork/poly-go-sdk"
+	"github.com/polynetwork/poly/common"
+)
+
+// CommitteeEpoch describes the Fabric MSP / orderer committee that is
+// currently trusted on the Poly side. Epochs are produced by HeaderSyncer
+// whenever it observes a config-block update on the monitored channel, and
+// referenced by height-based proofs instead of re-sending the full trust
+// chain on every commit. TrustChain carries the actual serialized
+// MultiCertTrustChain a verifier checks signatures against; MSPDigest just
+// binds the epoch to the specific config block it was derived from.
+type CommitteeEpoch struct {
+	Number     uint64
+	Height     uint64
+	MSPDigest  [32]byte
+	TrustChain []byte
+}
+
+// HeaderSyncer tracks Fabric channel config-block updates (MSP / orderer set
+// changes) and pushes the signer set's full trust chain to Poly's header_sync
+// contract on each one; it does not compute a delta against the previously
+// synced chain. This runs independently of cross-chain-event commits handled by
+// FabricManager. On-chain verifiers validate event proofs against the latest
+// epoch synced here - specifically its TrustChain - rather than against
+// certificates embedded in every tx.
+type HeaderSyncer struct {
+	config      *config.ServiceConfig
+	client      *tools.FabricSdk
+	polySdk     *sdk.PolySdk
+	polySigner  *sdk.Account
+	db          *db.BoltDB
+	signerSet   *SignerSet
+	sideChainId uint64
+
+	mu           sync.RWMutex
+	currentEpoch *CommitteeEpoch
+	lastHeight   uint64
+
+	inflight sync.WaitGroup
+}
+
+func NewHeaderSyncer(
+	servconfig *config.ServiceConfig,
+	ontsdk *sdk.PolySdk,
+	signer *sdk.Account,
+	client *tools.FabricSdk,
+	boltDB *db.BoltDB,
+	signerSet *SignerSet,
+) *HeaderSyncer {
+	return &HeaderSyncer{
+		config:      servconfig,
+		client:      client,
+		polySdk:     ontsdk,
+		polySigner:  signer,
+		db:          boltDB,
+		signerSet:   signerSet,
+		sideChainId: servconfig.FabricConfig.SideChainId,
+	}
+}
+
+// init recovers the last committee epoch this process synced to Poly from
+// the BoltDB journal, mirroring FabricManager.init's recovery of
+// currentHeight. Without it, every restart would resume numbering epochs
+// from 1 while Poly may already hold a higher one, silently breaking epoch
+// verification for any event proof committed afterward.
+func (h *HeaderSyncer) init() error {
+	epoch, ok, err := h.db.GetLatestEpoch()
+	if err != nil {
+		return fmt.Errorf("init - failed to load latest committee epoch: %v", err)
+	}
+	if !ok {
+		log.Infof("init - no committee epoch recorded yet, starting from scratch")
+		return nil
+	}
+	h.mu.Lock()
+	h.currentEpoch = epoch
+	h.lastHeight = epoch.Height
+	h.mu.Unlock()
+	log.Infof("init - recovered committee epoch %d at fabric height %d", epoch.Number, epoch.Height)
+	return nil
+}
+
+// CurrentEpoch returns the committee epoch last successfully synced to Poly,
+// or nil if the syncer has not completed an initial sync yet.
+func (h *HeaderSyncer) CurrentEpoch() *CommitteeEpoch {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.currentEpoch
+}
+
+// Wait blocks until any in-flight syncOnce call - and the BoltDB write it
+// makes - has finished. FabricManager.Shutdown calls this before closing the
+// shared BoltDB handle, since MonitorHeaderSync runs as its own goroutine
+// and isn't otherwise tracked by e.inflight.
+func (h *HeaderSyncer) Wait() {
+	h.inflight.Wait()
+}
+
+// MonitorHeaderSync polls the Fabric channel for config-block updates and
+// submits the full current trust chain to Poly whenever a new config block
+// appears.
+// It runs independently of MonitorChain's cross-chain-event loop until ctx
+// is cancelled.
+func (h *HeaderSyncer) MonitorHeaderSync(ctx context.Context) {
+	if err := h.init(); err != nil {
+		log.Errorf("MonitorHeaderSync - init failed: %v", err)
+		return
+	}
+	ticker := time.NewTicker(config.FABRIC_MONITOR_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("MonitorHeaderSync - context cancelled, stopping")
+			return
+		case <-ticker.C:
+			if err := h.syncOnce(); err != nil {
+				log.Errorf("MonitorHeaderSync - sync failed: %v", err)
+			}
+		}
+	}
+}
+
+func (h *HeaderSyncer) syncOnce() error {
+	configBlock, height, err := h.client.GetLatestConfigBlock()
+	if err != nil {
+		return err
+	}
+	if height == h.lastHeight {
+		return nil
+	}
+
+	sink := common.NewZeroCopySink(nil)
+	h.signerSet.TrustChain().Serialization(sink)
+
+	digest := sha256.Sum256(configBlock)
+	epoch := &CommitteeEpoch{
+		Number:     h.nextEpochNumber(),
+		Height:     height,
+		MSPDigest:  digest,
+		TrustChain: sink.Bytes(),
+	}
+
+	h.inflight.Add(1)
+	defer h.inflight.Done()
+
+	if err := h.submitEpoch(epoch); err != nil {
+		return err
+	}
+	if err := h.db.PutLatestEpoch(epoch); err != nil {
+		log.Errorf("syncOnce - failed to persist committee epoch %d: %v", epoch.Number, err)
+	}
+
+	h.mu.Lock()
+	h.currentEpoch = epoch
+	h.lastHeight = height
+	h.mu.Unlock()
+
+	log.Infof("MonitorHeaderSync - synced committee epoch %d at fabric height %d", epoch.Number, height)
+	return nil
+}
+
+func (h *HeaderSyncer) nextEpochNumber() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.currentEpoch == nil {
+		return 1
+	}
+	return h.currentEpoch.Number + 1
+}
+
+func (h *HeaderSyncer) submitEpoch(epoch *CommitteeEpoch) error {
+	header := config.MSPHeader{
+		Number:     epoch.Number,
+		Height:     epoch.Height,
+		MSPDigest:  epoch.MSPDigest[:],
+		TrustChain: epoch.TrustChain,
+	}
+	tx, err := h.polySdk.Native.Hs.SyncBlockHeader(
+		h.sideChainId,
+		h.polySigner.Address,
+		[][]byte{header.Serialize()},
+		h.polySigner,
+	)
+	if err != nil {
+		return err
+	}
+	log.Infof("submitEpoch - sent header_sync tx %s for epoch %d", tx.ToHexString(), epoch.Number)
+	return nil
+}
@@ -0,0 +1,310 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"container/heap"
+	"context"
+	"crypto"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/polynetwork/fabric-relayer/log"
+	"github.com/polynetwork/poly/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	pipelineQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabric_relayer",
+		Name:      "pipeline_queue_depth",
+		Help:      "Number of heights queued for the signing workers but not yet picked up.",
+	})
+	pipelineSignLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "fabric_relayer",
+		Name:      "pipeline_sign_latency_seconds",
+		Help:      "Time spent computing event digests and signatures per block.",
+	})
+	pipelineCommitLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "fabric_relayer",
+		Name:      "pipeline_commit_lag_blocks",
+		Help:      "Difference between the highest fetched height and currentHeight.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(pipelineQueueDepth, pipelineSignLatency, pipelineCommitLag)
+}
+
+// signedBlock is the unit of work handed from a worker to the committer: the
+// original block height plus its events, already digested and signed.
+type signedBlock struct {
+	height    uint64
+	blockHash []byte
+	events    []signedEvent
+}
+
+type signedEvent struct {
+	txhash []byte
+	value  []byte
+	sigs   [][]byte
+}
+
+// blockHeap is a min-heap of signedBlock ordered by height, letting the
+// committer always submit the lowest pending height first even though
+// workers may finish signing out of order.
+type blockHeap []*signedBlock
+
+func (h blockHeap) Len() int            { return len(h) }
+func (h blockHeap) Less(i, j int) bool  { return h[i].height < h[j].height }
+func (h blockHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *blockHeap) Push(x interface{}) { *h = append(*h, x.(*signedBlock)) }
+func (h *blockHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// pipeline fans a range of heights out to a bounded pool of signing workers
+// and fans their results back in through a height-ordered commit queue, so
+// MonitorChain no longer signs and submits one block at a time.
+type pipeline struct {
+	mgr     *FabricManager
+	workers int
+}
+
+func newPipeline(mgr *FabricManager, workers int) *pipeline {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &pipeline{mgr: mgr, workers: workers}
+}
+
+// run fetches and signs (from, to] and commits the resulting blocks to Poly
+// in height order, returning the last height that was successfully
+// committed. It returns early, without error, on the first height it
+// cannot sign or commit, leaving currentHeight advancement to the caller.
+func (p *pipeline) run(ctx context.Context, from, to uint64) uint64 {
+	if to <= from {
+		return from
+	}
+
+	// runCtx is scoped to this run, not to MonitorChain's lifetime: commit
+	// returning early on an ordinary error (reorg, no epoch, a failed
+	// ImportOuterTransfer) must still unblock any signWorker still parked on
+	// `out <- block`, or it leaks until process shutdown cancels ctx.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	heights := make(chan uint64, p.workers*2)
+	signed := make(chan *signedBlock, p.workers*2)
+
+	var wg sync.WaitGroup
+	wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			defer wg.Done()
+			p.signWorker(runCtx, heights, signed)
+		}()
+	}
+
+	go func() {
+		defer close(heights)
+		for h := from + 1; h <= to; h++ {
+			select {
+			case heights <- h:
+				pipelineQueueDepth.Inc()
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(signed)
+	}()
+
+	return p.commit(runCtx, from, to, signed)
+}
+
+func (p *pipeline) signWorker(ctx context.Context, heights <-chan uint64, out chan<- *signedBlock) {
+	for {
+		select {
+		case height, ok := <-heights:
+			if !ok {
+				return
+			}
+			pipelineQueueDepth.Dec()
+			block, err := p.signHeight(height)
+			if err != nil {
+				log.Errorf("pipeline - failed to sign height %d: %v", height, err)
+				continue
+			}
+			select {
+			case out <- block:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *pipeline) signHeight(height uint64) (*signedBlock, error) {
+	start := time.Now()
+	defer func() { pipelineSignLatency.Observe(time.Since(start).Seconds()) }()
+
+	blockHash, err := p.mgr.client.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+	rawEvents, err := p.mgr.client.GetCrossChainEvent(height)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]signedEvent, 0, len(rawEvents))
+	for _, ev := range rawEvents {
+		signed, err := p.signValue(ev.Data)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, signedEvent{txhash: ev.TxHash, value: ev.Data, sigs: signed})
+	}
+
+	privateEvents, err := p.mgr.fetchPrivateDataProofs(height)
+	if err != nil {
+		return nil, err
+	}
+	for _, pe := range privateEvents {
+		sink := common.NewZeroCopySink(nil)
+		pe.proof.Serialization(sink)
+		value := sink.Bytes()
+
+		signed, err := p.signValue(value)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, signedEvent{txhash: pe.txhash, value: value, sigs: signed})
+	}
+
+	return &signedBlock{height: height, blockHash: blockHash, events: events}, nil
+}
+
+func (p *pipeline) signValue(value []byte) ([][]byte, error) {
+	hash := crypto.SHA256.New()
+	hash.Write(value)
+	digest := hash.Sum(nil)
+	return p.mgr.signerSet.Sign(digest)
+}
+
+// commit drains signed blocks into a min-heap and submits them to Poly in
+// strict height order, only advancing past a height once every lower
+// pending height has been committed. Once signed is closed, the height the
+// committer is waiting on may simply never arrive (signHeight errored for
+// it and the worker moved on) - closed tracks that so the loop returns
+// instead of busy-spinning on the now-always-ready closed channel.
+func (p *pipeline) commit(ctx context.Context, from, to uint64, signed <-chan *signedBlock) uint64 {
+	pending := &blockHeap{}
+	heap.Init(pending)
+	next := from + 1
+	committed := from
+	closed := false
+
+	for next <= to {
+		if closed {
+			if pending.Len() == 0 || (*pending)[0].height != next {
+				return committed
+			}
+		} else {
+			select {
+			case block, ok := <-signed:
+				if !ok {
+					closed = true
+				} else {
+					heap.Push(pending, block)
+				}
+			case <-ctx.Done():
+				return committed
+			}
+		}
+
+		for pending.Len() > 0 && (*pending)[0].height == next {
+			block := heap.Pop(pending).(*signedBlock)
+			if err := p.commitBlock(ctx, block); err != nil {
+				log.Errorf("pipeline - failed to commit height %d: %v", block.height, err)
+				return committed
+			}
+			committed = next
+			next++
+			pipelineCommitLag.Set(float64(to - committed))
+		}
+	}
+	return committed
+}
+
+func (p *pipeline) commitBlock(ctx context.Context, block *signedBlock) error {
+	reorged, _, err := p.mgr.checkReorg(block.height, block.blockHash)
+	if err != nil {
+		return err
+	}
+	if reorged {
+		if err := p.mgr.handleReorg(block.height); err != nil {
+			return err
+		}
+		return fmt.Errorf("reorg detected at height %d", block.height)
+	}
+
+	for _, ev := range block.events {
+		polyTxHash, err := p.mgr.commitSignedEvent(ctx, uint32(block.height), ev.value, ev.sigs)
+		if err != nil {
+			return err
+		}
+		if err := p.mgr.db.PutBlockRecord(&BlockRecord{
+			Height:     block.height,
+			BlockHash:  block.blockHash,
+			TxHash:     ev.txhash,
+			PolyTxHash: polyTxHash,
+			Status:     BlockStatusCommitted,
+		}); err != nil {
+			log.Errorf("persist block record err: %v", err)
+		}
+	}
+	if len(block.events) == 0 {
+		if err := p.mgr.db.PutBlockRecord(&BlockRecord{
+			Height:    block.height,
+			BlockHash: block.blockHash,
+			Status:    BlockStatusCommitted,
+		}); err != nil {
+			log.Errorf("persist block record err: %v", err)
+		}
+	}
+	return nil
+}
+
+// MetricsHandler exposes the pipeline's queue depth, signing latency, and
+// commit lag as a Prometheus scrape endpoint, e.g. mounted at /metrics.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
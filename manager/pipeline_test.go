@@ -0,0 +1,93 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"container/heap"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBlockHeapOrdersByHeight(t *testing.T) {
+	h := &blockHeap{}
+	heap.Init(h)
+	for _, height := range []uint64{5, 1, 3, 2, 4} {
+		heap.Push(h, &signedBlock{height: height})
+	}
+
+	want := []uint64{1, 2, 3, 4, 5}
+	for i, w := range want {
+		if h.Len() == 0 {
+			t.Fatalf("heap emptied early at index %d, want height %d", i, w)
+		}
+		got := heap.Pop(h).(*signedBlock).height
+		if got != w {
+			t.Fatalf("pop %d: got height %d, want %d", i, got, w)
+		}
+	}
+}
+
+// TestCommitReturnsOnPermanentGap is the regression test for the busy-loop
+// bug: if signHeight failed for a height, it never reaches signed, and once
+// signed is closed the next required height can never arrive. commit must
+// notice and return rather than spin selecting on the now-always-ready
+// closed channel. Height 1 here never arrives, so commit must return
+// without ever calling commitBlock (and therefore without needing a real
+// p.mgr at all).
+func TestCommitReturnsOnPermanentGap(t *testing.T) {
+	p := &pipeline{workers: 1}
+
+	signed := make(chan *signedBlock, 3)
+	signed <- &signedBlock{height: 3}
+	signed <- &signedBlock{height: 4}
+	signed <- &signedBlock{height: 5}
+	close(signed)
+
+	done := make(chan uint64, 1)
+	go func() { done <- p.commit(context.Background(), 0, 5, signed) }()
+
+	select {
+	case committed := <-done:
+		if committed != 0 {
+			t.Fatalf("got committed=%d, want 0 (height 1 never arrived, so nothing should have committed)", committed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("commit did not return - busy-looping on the permanent gap at height 1")
+	}
+}
+
+// TestCommitReturnsOnCtxCancel exercises commit's other early-exit path:
+// cancelling ctx should unblock a commit that's waiting on signed.
+func TestCommitReturnsOnCtxCancel(t *testing.T) {
+	p := &pipeline{workers: 1}
+	signed := make(chan *signedBlock)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan uint64, 1)
+	go func() { done <- p.commit(ctx, 0, 5, signed) }()
+	cancel()
+
+	select {
+	case committed := <-done:
+		if committed != 0 {
+			t.Fatalf("got committed=%d, want 0", committed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("commit did not return after ctx was cancelled")
+	}
+}
@@ -0,0 +1,124 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/polynetwork/fabric-relayer/log"
+	"github.com/polynetwork/poly/common"
+)
+
+// PrivateDataProof carries a Fabric private-data (SideDB) payload revealed
+// to the relayer alongside the collection hash that was actually committed
+// on the public ledger, so the Poly-side verifier can check the payload
+// against that commitment rather than trust a plain event value.
+type PrivateDataProof struct {
+	Chaincode      string
+	Collection     string
+	CollectionHash []byte
+	Payload        []byte
+}
+
+func (p *PrivateDataProof) Serialization(sink *common.ZeroCopySink) {
+	sink.WriteString(p.Chaincode)
+	sink.WriteString(p.Collection)
+	sink.WriteVarBytes(p.CollectionHash)
+	sink.WriteVarBytes(p.Payload)
+}
+
+func (p *PrivateDataProof) Deserialization(source *common.ZeroCopySource) error {
+	var eof bool
+	if p.Chaincode, _, _, eof = source.NextString(); eof {
+		return fmt.Errorf("PrivateDataProof.Deserialization - failed to read chaincode")
+	}
+	if p.Collection, _, _, eof = source.NextString(); eof {
+		return fmt.Errorf("PrivateDataProof.Deserialization - failed to read collection")
+	}
+	if p.CollectionHash, _, _, eof = source.NextVarBytes(); eof {
+		return fmt.Errorf("PrivateDataProof.Deserialization - failed to read collection hash")
+	}
+	if p.Payload, _, _, eof = source.NextVarBytes(); eof {
+		return fmt.Errorf("PrivateDataProof.Deserialization - failed to read payload")
+	}
+	return nil
+}
+
+// privateDataEvent pairs a verified PrivateDataProof with the Fabric tx hash
+// it was revealed from, so callers don't have to guess which of several
+// private-data events in a block a given proof came from.
+type privateDataEvent struct {
+	proof  *PrivateDataProof
+	txhash []byte
+}
+
+// allowedPrivateData reports whether (chaincode, collection) is in the
+// configured allow-list of SideDB collections the relayer should surface as
+// cross-chain events.
+func (e *FabricManager) allowedPrivateData(chaincode, collection string) bool {
+	for _, entry := range e.config.FabricConfig.PrivateDataAllowList {
+		if entry.Chaincode == chaincode && entry.Collection == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchPrivateDataProofs reads the CollectionHashedRwset hashes committed at
+// height, keeps only the allow-listed (chaincode, collection) pairs, pulls
+// the actual private payload for each from a gossip-authorized peer, and
+// verifies it hashes to the on-chain commitment before returning it. Each
+// returned event keeps the tx hash it was revealed from, since a block can
+// carry private-data writes from more than one Fabric transaction.
+func (e *FabricManager) fetchPrivateDataProofs(height uint64) ([]*privateDataEvent, error) {
+	hashes, err := e.client.GetPrivateDataHashes(height)
+	if err != nil {
+		return nil, fmt.Errorf("fetchPrivateDataProofs - failed to read private data hashes: %v", err)
+	}
+
+	var events []*privateDataEvent
+	for _, h := range hashes {
+		if !e.allowedPrivateData(h.Chaincode, h.Collection) {
+			continue
+		}
+
+		payload, err := e.client.GetPrivateData(h.Chaincode, h.Collection, h.TxHash)
+		if err != nil {
+			log.Errorf("fetchPrivateDataProofs - failed to fetch private data for %s/%s: %v", h.Chaincode, h.Collection, err)
+			continue
+		}
+
+		digest := sha256.Sum256(payload)
+		if !bytes.Equal(digest[:], h.CollectionHash) {
+			log.Errorf("fetchPrivateDataProofs - private data hash mismatch for %s/%s at height %d", h.Chaincode, h.Collection, height)
+			continue
+		}
+
+		events = append(events, &privateDataEvent{
+			proof: &PrivateDataProof{
+				Chaincode:      h.Chaincode,
+				Collection:     h.Collection,
+				CollectionHash: h.CollectionHash,
+				Payload:        payload,
+			},
+			txhash: h.TxHash,
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,198 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/polynetwork/fabric-relayer/log"
+)
+
+// reorgCheckWindow is how many already-committed heights, up to and including
+// currentHeight, verifyRecentHeights re-verifies against the live chain on every tick.
+const reorgCheckWindow = 10
+
+// BlockStatus records whether a handled block's submission to Poly is still
+// part of the canonical Fabric view.
+type BlockStatus string
+
+const (
+	BlockStatusCommitted BlockStatus = "Committed"
+	BlockStatusRetracted BlockStatus = "Retracted"
+)
+
+// BlockRecord is the unit persisted to the rollback journal bucket for every
+// block the pipeline commits. It is keyed by (Height, BlockHash) so a
+// re-delivery of the same block is a no-op, while a divergent BlockHash at
+// the same height signals a reorg.
+type BlockRecord struct {
+	Height     uint64
+	BlockHash  []byte
+	TxHash     []byte
+	PolyTxHash string
+	Status     BlockStatus
+}
+
+// checkReorg compares the block hash this process previously recorded for
+// height against the hash the node currently reports. It returns hadRecord
+// = false, reorged = false when there is nothing recorded to compare
+// against yet - true for the next unprocessed height commitBlock calls this
+// with on every normal tick, but also true, within verifyRecentHeights'
+// window, of a height we should already have a record for (e.g. a fresh
+// BoltDB redeployed against an already-advanced Poly height). Callers that
+// care about that distinction should inspect hadRecord themselves;
+// checkReorg itself only ever signals reorged for a height it can actually
+// compare.
+func (e *FabricManager) checkReorg(height uint64, liveHash []byte) (reorged bool, hadRecord bool, err error) {
+	return checkReorgWith(e.db.GetBlockRecord, height, liveHash)
+}
+
+// checkReorgWith holds checkReorg's actual comparison logic behind the
+// record lookup as a plain func value, so it can be unit tested against a
+// fake journal instead of a real BoltDB file.
+func checkReorgWith(getRecord func(uint64) (*BlockRecord, bool, error), height uint64, liveHash []byte) (reorged bool, hadRecord bool, err error) {
+	rec, ok, err := getRecord(height)
+	if err != nil {
+		return false, false, fmt.Errorf("checkReorg - failed to load block record at %d: %v", height, err)
+	}
+	if !ok {
+		return false, false, nil
+	}
+	return !bytes.Equal(rec.BlockHash, liveHash), true, nil
+}
+
+// verifyRecentHeights re-checks the last window already-committed heights
+// against the live chain. checkReorg alone never catches an ordinary reorg:
+// it's only ever called by commitBlock with the next unprocessed height,
+// which has no prior record yet, so a divergence in a height we already
+// submitted to Poly would otherwise go unnoticed until something else
+// happened to touch it. This is what actually drives handleReorg/Rewind on
+// a normal MonitorChain tick. Every height in this window was supposedly
+// already committed, so a missing record here (unlike in commitBlock's
+// call) is itself worth a warning - most likely a BoltDB journal that was
+// reset or redeployed out from under an already-advanced currentHeight.
+func (e *FabricManager) verifyRecentHeights(window uint64) error {
+	current := e.CurrentHeight()
+	from := uint64(1)
+	if current > window {
+		from = current - window + 1
+	}
+	for h := from; h <= current; h++ {
+		liveHash, err := e.client.GetBlockHash(h)
+		if err != nil {
+			return fmt.Errorf("verifyRecentHeights - failed to fetch live block hash at %d: %v", h, err)
+		}
+		reorged, hadRecord, err := e.checkReorg(h, liveHash)
+		if err != nil {
+			return err
+		}
+		if !hadRecord {
+			log.Errorf("verifyRecentHeights - no journal record for already-committed height %d, journal may be stale", h)
+			continue
+		}
+		if reorged {
+			return e.handleReorg(h)
+		}
+	}
+	return nil
+}
+
+// findCommonAncestor walks backwards from height comparing our recorded
+// block hashes against the live chain until it finds a height where they
+// agree again, and returns that height.
+func (e *FabricManager) findCommonAncestor(height uint64) (uint64, error) {
+	return findCommonAncestorWith(e.db.GetBlockRecord, e.client.GetBlockHash, height)
+}
+
+// findCommonAncestorWith holds findCommonAncestor's walk-back logic behind
+// the record/live-hash lookups as plain func values, so it can be unit
+// tested against fake journal and chain data instead of a real BoltDB file
+// and Fabric SDK connection.
+func findCommonAncestorWith(
+	getRecord func(uint64) (*BlockRecord, bool, error),
+	getLiveHash func(uint64) ([]byte, error),
+	height uint64,
+) (uint64, error) {
+	for h := height; h > 0; h-- {
+		rec, ok, err := getRecord(h)
+		if err != nil {
+			return 0, fmt.Errorf("findCommonAncestor - failed to load block record at %d: %v", h, err)
+		}
+		if !ok {
+			continue
+		}
+		liveHash, err := getLiveHash(h)
+		if err != nil {
+			return 0, fmt.Errorf("findCommonAncestor - failed to fetch live block hash at %d: %v", h, err)
+		}
+		if bytes.Equal(rec.BlockHash, liveHash) {
+			return h, nil
+		}
+	}
+	return 0, nil
+}
+
+// Rewind marks every recorded Poly submission above height as Retracted and
+// resets currentHeight so MonitorChain re-processes the diverged range. It
+// is safe to call concurrently with MonitorChain since currentHeight is
+// guarded by heightMu and pipeline commits are idempotent per
+// (height, blockHash).
+func (e *FabricManager) Rewind(height uint64) error {
+	retracted, err := e.db.RetractBlockRecordsAbove(height)
+	if err != nil {
+		return fmt.Errorf("Rewind - failed to retract block records above %d: %v", height, err)
+	}
+	e.setCurrentHeight(height)
+	log.Infof("Rewind - rolled back to height %d, retracted %d prior submissions", height, retracted)
+	return nil
+}
+
+// handleReorg walks back to the common ancestor and rewinds the manager to
+// it. It is invoked from verifyRecentHeights and commitBlock when checkReorg
+// reports a mismatch.
+func (e *FabricManager) handleReorg(height uint64) error {
+	ancestor, err := e.findCommonAncestor(height)
+	if err != nil {
+		return err
+	}
+	log.Errorf("handleReorg - detected reorg at height %d, rewinding to common ancestor %d", height, ancestor)
+	return e.Rewind(ancestor)
+}
+
+// RewindHandler exposes FabricManager.Rewind over HTTP so operators can
+// force a rewind without restarting the process, e.g. POST /rewind?height=123.
+func (e *FabricManager) RewindHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	height, err := strconv.ParseUint(r.URL.Query().Get("height"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid height", http.StatusBadRequest)
+		return
+	}
+	if err := e.Rewind(height); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rewound_to": height})
+}
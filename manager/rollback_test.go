@@ -0,0 +1,97 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"errors"
+	"testing"
+)
+
+func fakeRecordStore(records map[uint64]*BlockRecord) func(uint64) (*BlockRecord, bool, error) {
+	return func(height uint64) (*BlockRecord, bool, error) {
+		rec, ok := records[height]
+		return rec, ok, nil
+	}
+}
+
+func TestCheckReorgWith(t *testing.T) {
+	records := map[uint64]*BlockRecord{
+		5: {Height: 5, BlockHash: []byte("hash-5")},
+	}
+
+	reorged, hadRecord, err := checkReorgWith(fakeRecordStore(records), 5, []byte("hash-5"))
+	if err != nil || hadRecord != true || reorged != false {
+		t.Fatalf("matching hash: got reorged=%v hadRecord=%v err=%v, want false/true/nil", reorged, hadRecord, err)
+	}
+
+	reorged, hadRecord, err = checkReorgWith(fakeRecordStore(records), 5, []byte("hash-5-different"))
+	if err != nil || hadRecord != true || reorged != true {
+		t.Fatalf("diverged hash: got reorged=%v hadRecord=%v err=%v, want true/true/nil", reorged, hadRecord, err)
+	}
+
+	reorged, hadRecord, err = checkReorgWith(fakeRecordStore(records), 6, []byte("hash-6"))
+	if err != nil || hadRecord != false || reorged != false {
+		t.Fatalf("no record: got reorged=%v hadRecord=%v err=%v, want false/false/nil", reorged, hadRecord, err)
+	}
+
+	wantErr := errors.New("boltdb is on fire")
+	_, _, err = checkReorgWith(func(uint64) (*BlockRecord, bool, error) { return nil, false, wantErr }, 5, nil)
+	if err == nil {
+		t.Fatalf("expected an error to propagate from the record store")
+	}
+}
+
+func TestFindCommonAncestorWith(t *testing.T) {
+	records := map[uint64]*BlockRecord{
+		1: {Height: 1, BlockHash: []byte("hash-1")},
+		2: {Height: 2, BlockHash: []byte("hash-2")},
+		3: {Height: 3, BlockHash: []byte("hash-3-stale")},
+	}
+	live := map[uint64][]byte{
+		1: []byte("hash-1"),
+		2: []byte("hash-2"),
+		3: []byte("hash-3-live"),
+	}
+	getLiveHash := func(h uint64) ([]byte, error) { return live[h], nil }
+
+	ancestor, err := findCommonAncestorWith(fakeRecordStore(records), getLiveHash, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ancestor != 2 {
+		t.Fatalf("got ancestor %d, want 2", ancestor)
+	}
+
+	// Heights 1 and 2 are never recorded, so the walk-back should skip them
+	// and report no ancestor rather than erroring.
+	sparse := map[uint64]*BlockRecord{
+		3: {Height: 3, BlockHash: []byte("hash-3-stale")},
+	}
+	ancestor, err = findCommonAncestorWith(fakeRecordStore(sparse), getLiveHash, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ancestor != 0 {
+		t.Fatalf("got ancestor %d, want 0 (no common ancestor found)", ancestor)
+	}
+
+	wantErr := errors.New("fabric node unreachable")
+	_, err = findCommonAncestorWith(fakeRecordStore(records), func(uint64) ([]byte, error) { return nil, wantErr }, 3)
+	if err == nil {
+		t.Fatalf("expected an error to propagate from the live-hash lookup")
+	}
+}
@@ -0,0 +1,250 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/miekg/pkcs11"
+	"github.com/tjfoc/gmsm/pkcs12"
+)
+
+// Signer abstracts over where an event-proof signing key actually lives, so
+// the pipeline's event signing no longer has to assume a PEM file on local
+// disk. Sign returns a signature over digest in whatever encoding the
+// backend produces, so its length is not fixed across backends or calls.
+// ID names the signer in error messages.
+type Signer interface {
+	Sign(digest []byte) ([]byte, error)
+	Public() crypto.PublicKey
+	ID() string
+}
+
+// NewSigner builds a Signer from a URI whose scheme selects the backend:
+// file:// (PEM on disk, the historical behavior), pkcs11:// (HSM), or
+// vault:// (HashiCorp Vault Transit). Unscheme'd paths are treated as
+// file:// for backward compatibility with the old PrivateKeyFiles config.
+func NewSigner(uri string) (Signer, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return newFileSigner(uri)
+	}
+	switch u.Scheme {
+	case "file":
+		// A relative path such as file://keys/a.pem parses with "keys" as
+		// the host, so rejoin host and path.
+		return newFileSigner(u.Host + u.Path)
+	case "pkcs11":
+		return newPKCS11Signer(u)
+	case "vault":
+		return newVaultSigner(u)
+	default:
+		return nil, fmt.Errorf("NewSigner - unsupported signer scheme %q", u.Scheme)
+	}
+}
+
+type fileSigner struct {
+	path string
+	key  *ecdsa.PrivateKey
+}
+
+func newFileSigner(path string) (Signer, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("newFileSigner - failed to read %s: %v", path, err)
+	}
+	blk, _ := pem.Decode(raw)
+	if blk == nil {
+		return nil, fmt.Errorf("newFileSigner - %s is not PEM-encoded", path)
+	}
+	key, err := pkcs12.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("newFileSigner - failed to parse %s to private key: %v", path, err)
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("newFileSigner - %s does not hold an ecdsa key", path)
+	}
+	return &fileSigner{path: path, key: ecdsaKey}, nil
+}
+
+func (s *fileSigner) Sign(digest []byte) ([]byte, error) {
+	return s.key.Sign(rand.Reader, digest, nil)
+}
+
+func (s *fileSigner) Public() crypto.PublicKey { return &s.key.PublicKey }
+func (s *fileSigner) ID() string               { return "file://" + s.path }
+
+// pkcs11Signer signs through a PKCS#11 HSM session. The private key never
+// leaves the device; Sign performs a remote C_Sign call keyed by label.
+type pkcs11Signer struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	label   string
+	pub     crypto.PublicKey
+}
+
+func newPKCS11Signer(u *url.URL) (Signer, error) {
+	module := u.Query().Get("module")
+	label := u.Query().Get("label")
+	pin := u.Query().Get("pin")
+	if module == "" || label == "" {
+		return nil, fmt.Errorf("newPKCS11Signer - module and label are required, got %s", u.String())
+	}
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("newPKCS11Signer - failed to load module %s", module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		return nil, fmt.Errorf("newPKCS11Signer - initialize failed: %v", err)
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		return nil, fmt.Errorf("newPKCS11Signer - no slots available: %v", err)
+	}
+	session, err := ctx.OpenSession(slots[0], pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("newPKCS11Signer - open session failed: %v", err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		return nil, fmt.Errorf("newPKCS11Signer - login failed: %v", err)
+	}
+
+	pub, err := findPKCS11PublicKey(ctx, session, label)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Signer{ctx: ctx, session: session, label: label, pub: pub}, nil
+}
+
+func (s *pkcs11Signer) Sign(digest []byte) ([]byte, error) {
+	priv, err := findPKCS11PrivateKeyHandle(s.ctx, s.session, s.label)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, priv); err != nil {
+		return nil, fmt.Errorf("pkcs11Signer.Sign - SignInit failed: %v", err)
+	}
+	return s.ctx.Sign(s.session, digest)
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey { return s.pub }
+func (s *pkcs11Signer) ID() string               { return "pkcs11://" + s.label }
+
+func findPKCS11ObjectHandle(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, error) {
+	tmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, tmpl); err != nil {
+		return 0, fmt.Errorf("findPKCS11ObjectHandle - FindObjectsInit failed: %v", err)
+	}
+	defer ctx.FindObjectsFinal(session)
+	objs, _, err := ctx.FindObjects(session, 1)
+	if err != nil || len(objs) == 0 {
+		return 0, fmt.Errorf("findPKCS11ObjectHandle - no object found for label %s: %v", label, err)
+	}
+	return objs[0], nil
+}
+
+func findPKCS11PrivateKeyHandle(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (pkcs11.ObjectHandle, error) {
+	return findPKCS11ObjectHandle(ctx, session, pkcs11.CKO_PRIVATE_KEY, label)
+}
+
+func findPKCS11PublicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string) (crypto.PublicKey, error) {
+	handle, err := findPKCS11ObjectHandle(ctx, session, pkcs11.CKO_PUBLIC_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("findPKCS11PublicKey - GetAttributeValue failed: %v", err)
+	}
+	return attrs[0].Value, nil
+}
+
+// vaultSigner signs through a HashiCorp Vault Transit secrets engine key.
+type vaultSigner struct {
+	client  *api.Client
+	keyName string
+	pub     crypto.PublicKey
+}
+
+func newVaultSigner(u *url.URL) (Signer, error) {
+	keyName := u.Query().Get("key")
+	if keyName == "" {
+		return nil, fmt.Errorf("newVaultSigner - key name is required, got %s", u.String())
+	}
+	cfg := api.DefaultConfig()
+	cfg.Address = u.Query().Get("addr")
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("newVaultSigner - failed to create vault client: %v", err)
+	}
+	if token := u.Query().Get("token"); token != "" {
+		client.SetToken(token)
+	}
+	return &vaultSigner{client: client, keyName: keyName}, nil
+}
+
+// Sign calls Vault's Transit sign endpoint and decodes its response, which
+// is wrapped as "vault:v<key version>:<base64 signature>", not a bare
+// base64 or ASCII signature.
+func (s *vaultSigner) Sign(digest []byte) ([]byte, error) {
+	secret, err := s.client.Logical().Write(fmt.Sprintf("transit/sign/%s", s.keyName), map[string]interface{}{
+		"input":     digest,
+		"prehashed": true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vaultSigner.Sign - transit sign failed: %v", err)
+	}
+	wrapped, ok := secret.Data["signature"].(string)
+	if !ok {
+		return nil, fmt.Errorf("vaultSigner.Sign - transit sign response missing signature")
+	}
+	return parseVaultSignature(wrapped)
+}
+
+// parseVaultSignature holds Sign's response-unwrapping logic as a pure
+// function of the wrapped string, so it can be unit tested without a real
+// Vault server.
+func parseVaultSignature(wrapped string) ([]byte, error) {
+	parts := strings.SplitN(wrapped, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("vaultSigner.Sign - unexpected signature format %q", wrapped)
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("vaultSigner.Sign - failed to decode signature: %v", err)
+	}
+	return sig, nil
+}
+
+func (s *vaultSigner) Public() crypto.PublicKey { return s.pub }
+func (s *vaultSigner) ID() string               { return "vault://" + s.keyName }
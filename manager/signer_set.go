@@ -0,0 +1,175 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/polynetwork/fabric-relayer/config"
+	"github.com/polynetwork/fabric-relayer/log"
+	scom "github.com/polynetwork/poly/native/service/header_sync/common"
+)
+
+// SignerSet holds the currently configured event-proof signers plus the
+// trust chain describing the committee they belong to, and lets both be
+// swapped out at runtime so key rotation no longer requires a restart.
+type SignerSet struct {
+	mu         sync.RWMutex
+	signers    []Signer
+	trustChain scom.MultiCertTrustChain
+}
+
+// NewSignerSet builds a SignerSet from config, resolving each signer URI to
+// its backend and building the associated trust chain.
+func NewSignerSet(cfg *config.FabricConfig) (*SignerSet, error) {
+	set := &SignerSet{}
+	if err := set.reload(cfg); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func (s *SignerSet) reload(cfg *config.FabricConfig) error {
+	uris := signerURIs(cfg)
+	signers := make([]Signer, len(uris))
+	for i, uri := range uris {
+		signer, err := NewSigner(uri)
+		if err != nil {
+			return fmt.Errorf("SignerSet.reload - failed to build signer %s: %v", uri, err)
+		}
+		signers[i] = signer
+	}
+
+	trustChain, err := loadTrustChain(cfg.TrustChainFiles)
+	if err != nil {
+		return fmt.Errorf("SignerSet.reload - failed to load trust chain: %v", err)
+	}
+
+	s.mu.Lock()
+	s.signers = signers
+	s.trustChain = trustChain
+	s.mu.Unlock()
+	return nil
+}
+
+// signerURIs returns the configured signer URIs, falling back to wrapping
+// the legacy PrivateKeyFiles list as file:// URIs so existing configs keep
+// working unchanged.
+func signerURIs(cfg *config.FabricConfig) []string {
+	if len(cfg.SignerURIs) > 0 {
+		return cfg.SignerURIs
+	}
+	uris := make([]string, len(cfg.PrivateKeyFiles))
+	for i, f := range cfg.PrivateKeyFiles {
+		uris[i] = "file://" + f
+	}
+	return uris
+}
+
+// Sign runs Sign against every configured signer in parallel and returns
+// their signatures in configuration order. It waits for every signer to
+// finish and, if any failed, returns only the first error in configuration
+// order, matching the previous all-or-nothing behavior.
+func (s *SignerSet) Sign(digest []byte) ([][]byte, error) {
+	s.mu.RLock()
+	signers := s.signers
+	s.mu.RUnlock()
+
+	sigs := make([][]byte, len(signers))
+	errs := make([]error, len(signers))
+
+	var wg sync.WaitGroup
+	wg.Add(len(signers))
+	for i, signer := range signers {
+		go func(i int, signer Signer) {
+			defer wg.Done()
+			sig, err := signer.Sign(digest)
+			if err != nil {
+				errs[i] = fmt.Errorf("signer %s failed: %v", signer.ID(), err)
+				return
+			}
+			sigs[i] = sig
+		}(i, signer)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sigs, nil
+}
+
+// TrustChain returns the trust chain that matches the currently loaded
+// signer set.
+func (s *SignerSet) TrustChain() scom.MultiCertTrustChain {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.trustChain
+}
+
+// Watch reloads the signer set whenever cfgFile changes on disk, so an
+// operator rotating keys only has to update config and rewrite the file -
+// no restart, and MonitorChain keeps running on the old set until the new
+// one finishes loading.
+func (s *SignerSet) Watch(ctx context.Context, cfgFile string, loadConfig func() (*config.FabricConfig, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("SignerSet.Watch - failed to create watcher: %v", err)
+	}
+	if err := watcher.Add(cfgFile); err != nil {
+		watcher.Close()
+		return fmt.Errorf("SignerSet.Watch - failed to watch %s: %v", cfgFile, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				cfg, err := loadConfig()
+				if err != nil {
+					log.Errorf("SignerSet.Watch - failed to reload %s: %v", cfgFile, err)
+					continue
+				}
+				if err := s.reload(cfg); err != nil {
+					log.Errorf("SignerSet.Watch - failed to apply reloaded config: %v", err)
+					continue
+				}
+				log.Infof("SignerSet.Watch - reloaded signer set from %s", cfgFile)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("SignerSet.Watch - watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
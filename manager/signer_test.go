@@ -0,0 +1,68 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseVaultSignature(t *testing.T) {
+	want := []byte{0x01, 0x02, 0x03, 0xff}
+	wrapped := "vault:v1:" + base64.StdEncoding.EncodeToString(want)
+
+	got, err := parseVaultSignature(wrapped)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+}
+
+func TestParseVaultSignatureHigherKeyVersion(t *testing.T) {
+	want := []byte{0xaa, 0xbb}
+	wrapped := "vault:v42:" + base64.StdEncoding.EncodeToString(want)
+
+	got, err := parseVaultSignature(wrapped)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+}
+
+func TestParseVaultSignatureRejectsWrongFormat(t *testing.T) {
+	for _, wrapped := range []string{
+		"",
+		"not-wrapped-at-all",
+		"vault:v1",
+		base64.StdEncoding.EncodeToString([]byte{0x01}),
+	} {
+		if _, err := parseVaultSignature(wrapped); err == nil {
+			t.Fatalf("parseVaultSignature(%q): expected an error, got none", wrapped)
+		}
+	}
+}
+
+func TestParseVaultSignatureRejectsBadBase64(t *testing.T) {
+	if _, err := parseVaultSignature("vault:v1:not-valid-base64!!!"); err == nil {
+		t.Fatal("expected an error decoding invalid base64, got none")
+	}
+}